@@ -0,0 +1,164 @@
+// Command validate draws Monte-Carlo samples for every (duration, sigma)
+// knot cdf_gen actually emits and checks the sampled ground truth against
+// the uint32 value that ended up in the generated Solidity tables.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/rand"
+
+	"github.com/GiantPandaZoo/OptionPandaContract/internal/cdfmodel"
+)
+
+// bucketReport is the per-(model, duration) entry of the JSON summary.
+type bucketReport struct {
+	Model       string  `json:"model"`
+	DurationSec uint64  `json:"durationSec"`
+	MaxAbsErr   float64 `json:"maxAbsErr"`
+	MaxStdErr   float64 `json:"maxStdErr"`
+	P99Err      float64 `json:"p99Err"`
+}
+
+func main() {
+	samples := flag.Int("samples", 100000, "Monte-Carlo samples drawn per sigma knot")
+	seed := flag.Uint64("seed", 1, "seed for the random source")
+	parallel := flag.Int("parallel", 1, "number of (model, duration) buckets validated concurrently")
+	sigmaTol := flag.Float64("sigmaTol", 6, "max error allowed before failing, in multiples of the Monte-Carlo standard error, so the gate's pass/fail threshold scales with -samples instead of self-failing on sampling noise at a fixed absolute tolerance")
+	nu := flag.Float64("nu", 5, "degrees of freedom, used for the Student model")
+	alpha := flag.Float64("alpha", 2, "shape, used for the Gamma model")
+	beta := flag.Float64("beta", 1, "rate, used for the Gamma model")
+	maxErr := flag.Float64("maxErr", 1e-4, "max piecewise-linear interpolation error tolerated when picking the sigma knot grid, must match cdf_gen's -maxErr")
+	flag.Parse()
+
+	var durations []time.Duration
+	for i := time.Duration(1); i <= 60; i++ {
+		durations = append(durations, i*time.Minute)
+	}
+	maxSigma := uint64(200)
+
+	models := cdfmodel.All(*nu, *alpha, *beta)
+
+	type job struct {
+		model cdfmodel.Model
+		knots []uint64
+		d     time.Duration
+	}
+	var jobs []job
+	for _, m := range models {
+		// Knots are picked once per model against the longest duration and
+		// reused across durations, mirroring cdf_gen exactly.
+		knots := cdfmodel.PickSigmaKnots(m, durations[len(durations)-1], maxSigma, *maxErr)
+		for _, d := range durations {
+			jobs = append(jobs, job{m, knots, d})
+		}
+	}
+
+	reports := make([]bucketReport, len(jobs))
+	sem := make(chan struct{}, *parallel)
+	var wg sync.WaitGroup
+
+	for i, j := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, j job) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			src := rand.NewSource(*seed + uint64(i))
+			reports[i] = validateDuration(j.model, j.d, j.knots, *samples, src)
+		}(i, j)
+	}
+	wg.Wait()
+
+	out, err := json.MarshalIndent(reports, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(string(out))
+
+	for _, rep := range reports {
+		if rep.MaxAbsErr > *sigmaTol*rep.MaxStdErr {
+			os.Exit(1)
+		}
+	}
+}
+
+// validateDuration compares the uint32 table cdf_gen emits for every knot in
+// the adaptive grid cdf_gen actually picked (knots) against a Monte-Carlo
+// estimate of the same quantity, sampled from model.
+func validateDuration(model cdfmodel.Model, d time.Duration, knots []uint64, samples int, src rand.Source) bucketReport {
+	var maxAbsErr, maxStdErr float64
+	var errs []float64
+
+	for _, s := range knots {
+		table := float64(cdfmodel.Calc(model, s, d)) / 1e9
+		empirical, stdErr := sampleTabulate(model, s, d, samples, src)
+		if err := math.Abs(table - empirical); err > maxAbsErr {
+			maxAbsErr = err
+		}
+		if stdErr > maxStdErr {
+			maxStdErr = stdErr
+		}
+		errs = append(errs, math.Abs(table-empirical))
+	}
+
+	return bucketReport{
+		Model:       model.Name(),
+		DurationSec: uint64(d / time.Second),
+		MaxAbsErr:   maxAbsErr,
+		MaxStdErr:   maxStdErr,
+		P99Err:      percentile(errs, 0.99),
+	}
+}
+
+// sampleTabulate draws samples directly from model's own distribution and
+// counts the fraction landing at or below z = cdfmodel.Z(s, d), giving a
+// Monte-Carlo estimate of CDF(z) independent of distuv's analytic CDF. It
+// then applies model.TabulateFromCDF, the same transform Tabulate itself
+// uses, so the result is directly comparable to cdfmodel.Calc's output.
+//
+// The standard error uses the Agresti-Coull adjustment (add 2 successes and
+// 4 trials before applying sqrt(p(1-p)/n)) rather than the raw binomial
+// formula: many knots land at tail probabilities small enough that
+// -samples draws zero hits, and the raw formula then reports a standard
+// error of exactly 0 even though the true probability isn't, making a
+// correct table fail the gate on rare-event buckets. The adjustment is then
+// scaled by TabulateFromCDF's slope (exactly 2 for the symmetric models, 1
+// for Gamma, since the transform is affine) to land in Tabulate(z)'s units.
+func sampleTabulate(model cdfmodel.Model, s uint64, d time.Duration, samples int, src rand.Source) (empirical, stdErr float64) {
+	z := cdfmodel.Z(s, d)
+
+	inside := 0
+	for i := 0; i < samples; i++ {
+		if model.Sample(src) <= z {
+			inside++
+		}
+	}
+
+	p := float64(inside) / float64(samples)
+	nAdj := float64(samples) + 4
+	pAdj := (float64(inside) + 2) / nAdj
+
+	scale := math.Abs(model.TabulateFromCDF(1) - model.TabulateFromCDF(0))
+	return model.TabulateFromCDF(p), scale * math.Sqrt(pAdj*(1-pAdj)/nAdj)
+}
+
+func percentile(vals []float64, p float64) float64 {
+	if len(vals) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), vals...)
+	for i := 1; i < len(sorted); i++ {
+		for j := i; j > 0 && sorted[j-1] > sorted[j]; j-- {
+			sorted[j-1], sorted[j] = sorted[j], sorted[j-1]
+		}
+	}
+	return sorted[int(p*float64(len(sorted)-1))]
+}
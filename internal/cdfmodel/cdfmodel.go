@@ -0,0 +1,178 @@
+// Package cdfmodel holds the sigma/duration CDF tabulation math shared by
+// cdf_gen (which emits it as Solidity tables) and cmd/validate (which
+// Monte-Carlo checks the emitted tables against it), so the two can't drift
+// out of sync on what "the table" actually is.
+package cdfmodel
+
+import (
+	"math"
+	"time"
+
+	"golang.org/x/exp/rand"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+const (
+	Day  = 24 * time.Hour
+	Year = 365 * Day
+)
+
+// Model lets the symmetric ATM table be driven by a distribution other than
+// the Normal, so the protocol can quote underlyings whose returns are
+// demonstrably heavy-tailed or skewed.
+type Model interface {
+	CDF(x float64) float64
+	Name() string
+	// Tabulate maps a standardized argument z to the quantity Calc
+	// actually stores. Zero-symmetric models (Normal, Student-t) store
+	// 2*CDF(z)-1. Gamma's support starts at 0, so 2*CDF(z)-1 goes
+	// negative for small z and wraps around once cast to uint32; Gamma
+	// stores CDF(z) directly instead, which stays in [0,1].
+	Tabulate(z float64) float64
+	// TabulateFromCDF applies the same transform Tabulate does, but to an
+	// already-computed CDF probability instead of calling CDF(z) itself.
+	// This lets cmd/validate plug in a Monte-Carlo estimate of CDF(z) in
+	// place of the exact analytic value and still land on the quantity
+	// the Solidity tables actually store.
+	TabulateFromCDF(p float64) float64
+	// Sample draws one observation from the model's own distribution, so
+	// cmd/validate can check Tabulate's CDF(z) call against real samples
+	// instead of only trusting distuv's analytic CDF implementation.
+	Sample(src rand.Source) float64
+}
+
+type NormalModel struct{ Dist distuv.Normal }
+
+func (m NormalModel) CDF(x float64) float64             { return m.Dist.CDF(x) }
+func (m NormalModel) Name() string                      { return "Normal" }
+func (m NormalModel) Tabulate(z float64) float64        { return m.TabulateFromCDF(m.CDF(z)) }
+func (m NormalModel) TabulateFromCDF(p float64) float64 { return 2*p - 1 }
+func (m NormalModel) Sample(src rand.Source) float64 {
+	d := m.Dist
+	d.Src = src
+	return d.Rand()
+}
+
+type StudentModel struct{ Dist distuv.StudentsT }
+
+func (m StudentModel) CDF(x float64) float64             { return m.Dist.CDF(x) }
+func (m StudentModel) Name() string                      { return "Student" }
+func (m StudentModel) Tabulate(z float64) float64        { return m.TabulateFromCDF(m.CDF(z)) }
+func (m StudentModel) TabulateFromCDF(p float64) float64 { return 2*p - 1 }
+func (m StudentModel) Sample(src rand.Source) float64 {
+	d := m.Dist
+	d.Src = src
+	return d.Rand()
+}
+
+type GammaModel struct{ Dist distuv.Gamma }
+
+func (m GammaModel) CDF(x float64) float64             { return m.Dist.CDF(x) }
+func (m GammaModel) Name() string                      { return "Gamma" }
+func (m GammaModel) Tabulate(z float64) float64        { return m.TabulateFromCDF(m.CDF(z)) }
+func (m GammaModel) TabulateFromCDF(p float64) float64 { return p }
+func (m GammaModel) Sample(src rand.Source) float64 {
+	d := m.Dist
+	d.Src = src
+	return d.Rand()
+}
+
+// Build constructs the Model named by name, applying whichever of
+// nu/alpha/beta are relevant to it.
+func Build(name string, nu, alpha, beta float64) Model {
+	switch name {
+	case "student":
+		return StudentModel{distuv.StudentsT{Mu: 0, Sigma: 1, Nu: nu}}
+	case "gamma":
+		return GammaModel{distuv.Gamma{Alpha: alpha, Beta: beta}}
+	default:
+		return NormalModel{distuv.Normal{Mu: 0, Sigma: 1}}
+	}
+}
+
+// All returns every model the Solidity tables dispatch between, in the same
+// order as the generated CDFModel enum (Normal, Student, Gamma).
+func All(nu, alpha, beta float64) []Model {
+	return []Model{
+		NormalModel{distuv.Normal{Mu: 0, Sigma: 1}},
+		StudentModel{distuv.StudentsT{Mu: 0, Sigma: 1, Nu: nu}},
+		GammaModel{distuv.Gamma{Alpha: alpha, Beta: beta}},
+	}
+}
+
+// Z returns the standardized CDF argument for a sigma bucket and duration,
+// shared by Calc and every diagnostic that needs to recompute the exact
+// (unquantised) value for the same bucket.
+func Z(s uint64, d time.Duration) float64 {
+	return float64(s) * math.Sqrt(float64(d)/float64(Year)) / 2 / 100
+}
+
+// Calc returns the uint32 1e9-scale fixed-point value the Solidity tables
+// store for a given model, sigma bucket and duration.
+func Calc(model Model, s uint64, d time.Duration) uint32 {
+	return uint32(1e9 * model.Tabulate(Z(s, d)))
+}
+
+// PickSigmaKnots evaluates Calc on a dense sigma grid for d and repeatedly
+// inserts the dense sigma point with the largest current piecewise-linear
+// interpolation error, until the max error is at most maxErr. It starts from
+// just the two endpoints, which is the non-uniform analogue of a fixed
+// step-5 grid.
+func PickSigmaKnots(model Model, d time.Duration, maxSigma uint64, maxErr float64) []uint64 {
+	dense := make([]uint64, maxSigma+1)
+	truth := make([]float64, maxSigma+1)
+	for s := uint64(0); s <= maxSigma; s++ {
+		dense[s] = s
+		truth[s] = float64(Calc(model, s, d)) / 1e9
+	}
+
+	knots := []uint64{0, maxSigma}
+	for {
+		worstIdx, worstErr := -1, 0.0
+		for i, s := range dense {
+			interp := Lerp(knots, model, d, s)
+			if err := math.Abs(interp - truth[i]); err > worstErr {
+				worstIdx, worstErr = i, err
+			}
+		}
+		if worstErr <= maxErr || worstIdx < 0 {
+			return knots
+		}
+		knots = insertSorted(knots, dense[worstIdx])
+	}
+}
+
+// Lerp linearly interpolates the CDF value at sigma between the two knots
+// bracketing it, mirroring what the on-chain lerpCDF helper does.
+func Lerp(knots []uint64, model Model, d time.Duration, sigma uint64) float64 {
+	lo, hi := knots[0], knots[len(knots)-1]
+	for i := 0; i < len(knots)-1; i++ {
+		if sigma >= knots[i] && sigma <= knots[i+1] {
+			lo, hi = knots[i], knots[i+1]
+			break
+		}
+	}
+	if lo == hi {
+		return float64(Calc(model, lo, d)) / 1e9
+	}
+	vLo := float64(Calc(model, lo, d)) / 1e9
+	vHi := float64(Calc(model, hi, d)) / 1e9
+	frac := float64(sigma-lo) / float64(hi-lo)
+	return vLo + frac*(vHi-vLo)
+}
+
+func insertSorted(knots []uint64, s uint64) []uint64 {
+	out := make([]uint64, 0, len(knots)+1)
+	inserted := false
+	for _, k := range knots {
+		if !inserted && s < k {
+			out = append(out, s)
+			inserted = true
+		}
+		out = append(out, k)
+	}
+	if !inserted {
+		out = append(out, s)
+	}
+	return out
+}